@@ -0,0 +1,112 @@
+package container
+
+import (
+	"os"
+	"runtime"
+	"sync"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// Accelerator identifies which virtualization acceleration the host can
+// provide for a guest domain.
+type Accelerator string
+
+const (
+	AcceleratorKVM Accelerator = "kvm"
+	AcceleratorHVF Accelerator = "hvf"
+	AcceleratorTCG Accelerator = "qemu"
+)
+
+// detectAccelerator probes the host for Apple's Hypervisor.framework (HVF,
+// on any darwin host), then KVM (a /dev/kvm it can open read-write), and
+// falls back to software emulation (TCG) otherwise - e.g. nested virt
+// disabled or unprivileged CI.
+func detectAccelerator() Accelerator {
+	if runtime.GOOS == "darwin" {
+		return AcceleratorHVF
+	}
+
+	if f, err := os.OpenFile("/dev/kvm", os.O_RDWR, 0); err == nil {
+		f.Close()
+		return AcceleratorKVM
+	}
+
+	return AcceleratorTCG
+}
+
+var (
+	acceleratorOnce sync.Once
+	detectedAccel   Accelerator
+)
+
+// detectedAccelerator probes the host once (from InitDriver for libvirt, or
+// from the qemu driver's first InitContext), and
+// returns the cached result on every later call, so hosts don't re-probe
+// /dev/kvm on every DomainXml()/commandLine() call.
+func detectedAccelerator() Accelerator {
+	acceleratorOnce.Do(func() {
+		detectedAccel = detectAccelerator()
+	})
+	return detectedAccel
+}
+
+// domainType is the <domain type=...> value for this accelerator. Libvirt's
+// QEMU driver has no "hvf" domain type - HVF is a qemu accelerator, reached
+// via "qemu" plus the -accel override in qemuCommandline().
+func (a Accelerator) domainType() string {
+	if a == AcceleratorHVF {
+		return string(AcceleratorTCG)
+	}
+	return string(a)
+}
+
+// cpu builds the <cpu> element DomainXml() should use for this accelerator.
+func (a Accelerator) cpu() *libvirtxml.DomainCPU {
+	switch a {
+	case AcceleratorKVM:
+		return &libvirtxml.DomainCPU{Mode: "host-model"}
+	case AcceleratorHVF:
+		return &libvirtxml.DomainCPU{Mode: "host-passthrough"}
+	default:
+		return &libvirtxml.DomainCPU{Model: &libvirtxml.DomainCPUModel{Value: tcgCPUModel()}}
+	}
+}
+
+// tcgCPUModel is the software-emulation CPU model for the current host
+// architecture. qemu64 is an x86-only model - on an arm64 TCG host paired
+// with osArchMachine()'s aarch64/virt machine, it would fail to start.
+func tcgCPUModel() string {
+	switch runtime.GOARCH {
+	case "arm64":
+		return "cortex-a57"
+	default:
+		return "qemu64"
+	}
+}
+
+// qemuCommandline returns the qemu:commandline override needed to actually
+// enable this accelerator, or nil if the <domain type=...>/<cpu> elements
+// already say everything libvirt needs to know.
+func (a Accelerator) qemuCommandline() *libvirtxml.DomainQEMUCommandline {
+	if a != AcceleratorHVF {
+		return nil
+	}
+	return &libvirtxml.DomainQEMUCommandline{
+		Args: []libvirtxml.DomainQEMUCommandlineArg{
+			{Value: "-accel"},
+			{Value: "hvf"},
+		},
+	}
+}
+
+// osArchMachine picks the <os><type arch=... machine=.../> pair for the
+// current host architecture, opening the door to aarch64 hosts.
+func osArchMachine() (arch, machine string) {
+	switch runtime.GOARCH {
+	case "arm64":
+		return "aarch64", "virt"
+	default:
+		return "x86_64", "pc-q35"
+	}
+}
@@ -0,0 +1,115 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// CloudInitProvider materializes whatever provisioning artifacts a guest
+// needs (a seed disk, firmware config, ...) and describes how to attach
+// them to the domain being built in DomainXml().
+type CloudInitProvider interface {
+	// Prepare writes the provider's artifacts into directory and returns
+	// the domain XML pieces needed to attach them.
+	Prepare(lc *LibvirtContext, directory string) (CloudInitArtifacts, error)
+}
+
+// CloudInitArtifacts are the domain pieces a CloudInitProvider contributes.
+// Either field may be left nil if the provider doesn't need it.
+type CloudInitArtifacts struct {
+	Disk            *libvirtxml.DomainDisk
+	QEMUCommandline *libvirtxml.DomainQEMUCommandline
+}
+
+// cloudInitProvider selects a CloudInitProvider for the given
+// Container.Config.QemuCloudInit mode, defaulting to NoCloud to preserve
+// existing behavior.
+func cloudInitProvider(mode string) CloudInitProvider {
+	switch mode {
+	case "ignition":
+		return &IgnitionProvider{}
+	default:
+		return &NoCloudProvider{}
+	}
+}
+
+// NoCloudProvider is the original behavior: a NoCloud ISO carrying
+// user-data/meta-data, attached as a cdrom.
+type NoCloudProvider struct{}
+
+func (p *NoCloudProvider) Prepare(lc *LibvirtContext, directory string) (CloudInitArtifacts, error) {
+	seedImagePath, err := lc.CreateSeedImage(directory)
+	if err != nil {
+		return CloudInitArtifacts{}, err
+	}
+
+	disk := libvirtxml.DomainDisk{
+		Device: "cdrom",
+		Driver: &libvirtxml.DomainDiskDriver{
+			Name: "qemu",
+			Type: "raw",
+		},
+		Source: &libvirtxml.DomainDiskSource{
+			File: &libvirtxml.DomainDiskSourceFile{
+				File: seedImagePath,
+			},
+		},
+		Target: &libvirtxml.DomainDiskTarget{
+			Dev: "sdb",
+			Bus: "scsi",
+		},
+		ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+	}
+
+	return CloudInitArtifacts{Disk: &disk}, nil
+}
+
+// IgnitionProvider boots CoreOS/Flatcar-family images. The Ignition config
+// comes from Container.Config.IgnitionConfig (inline JSON) or
+// Container.Config.IgnitionConfigPath (a path to read it from), is copied
+// into directory/ignition.json, and is handed to the guest firmware via a
+// QEMU fw_cfg entry rather than a disk.
+type IgnitionProvider struct{}
+
+func (p *IgnitionProvider) Prepare(lc *LibvirtContext, directory string) (CloudInitArtifacts, error) {
+	config, err := p.loadConfig(lc)
+	if err != nil {
+		return CloudInitArtifacts{}, err
+	}
+
+	configPath := directory + "/ignition.json"
+	if err := ioutil.WriteFile(configPath, config, 0600); err != nil {
+		return CloudInitArtifacts{}, fmt.Errorf("Could not write ignition config to %s", configPath)
+	}
+
+	// Requires the domain to be marshalled with the
+	// xmlns:qemu="http://libvirt.org/schemas/domain/qemu/1.0" namespace,
+	// which libvirtxml.Domain.Marshal() adds automatically whenever
+	// QEMUCommandline is set.
+	cmdline := &libvirtxml.DomainQEMUCommandline{
+		Args: []libvirtxml.DomainQEMUCommandlineArg{
+			{Value: "-fw_cfg"},
+			{Value: fmt.Sprintf("name=opt/com.coreos/config,file=%s", configPath)},
+		},
+	}
+
+	return CloudInitArtifacts{QEMUCommandline: cmdline}, nil
+}
+
+func (p *IgnitionProvider) loadConfig(lc *LibvirtContext) ([]byte, error) {
+	if lc.container.Config.IgnitionConfig != "" {
+		return []byte(lc.container.Config.IgnitionConfig), nil
+	}
+
+	if lc.container.Config.IgnitionConfigPath != "" {
+		data, err := ioutil.ReadFile(lc.container.Config.IgnitionConfigPath)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read ignition config %s", lc.container.Config.IgnitionConfigPath)
+		}
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("QemuCloudInit is \"ignition\" but no ignition config was provided")
+}
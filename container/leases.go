@@ -0,0 +1,85 @@
+package container
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	libvirtgo "github.com/rgbkrk/libvirt-go"
+)
+
+// leaseSources are tried in order: a DHCP lease is authoritative, the ARP
+// cache catches guests with static addressing, and the guest agent is the
+// last resort when neither knows about the interface yet.
+var leaseSources = []uint32{
+	libvirtgo.VIR_DOMAIN_INTERFACE_ADDRESSES_SRC_LEASE,
+	libvirtgo.VIR_DOMAIN_INTERFACE_ADDRESSES_SRC_ARP,
+	libvirtgo.VIR_DOMAIN_INTERFACE_ADDRESSES_SRC_AGENT,
+}
+
+// WaitForLeases polls libvirt after lc.domain.Create() until the NIC
+// configured in DomainXml() has acquired an address, then records it on
+// container.NetworkSettings. It gives up once timeout elapses, which is the
+// usual symptom of a seed image whose cloud-init failed before requesting a
+// lease. Modeled on terraform-provider-libvirt's domainWaitForLeases.
+func (lc *LibvirtContext) WaitForLeases(timeout time.Duration) (net.IP, error) {
+	if lc.domain == nil {
+		return nil, fmt.Errorf("Cannot wait for leases: no domain in LibvirtContext")
+	}
+
+	mode := NetworkMode(lc.container.Config.NetworkMode)
+	if mode == "" {
+		mode = NetworkModeBridge
+	}
+
+	switch mode {
+	case NetworkModeNone:
+		// DomainXml() attached no NIC at all; there is nothing to wait for.
+		return nil, nil
+	case NetworkModeUser:
+		// SLIRP user-mode networking doesn't expose a lease the host can
+		// observe - the guest gets a well-known address from qemu's own
+		// DHCP server instead, so there's no MAC to match against here.
+		return nil, nil
+	}
+
+	mac := lc.container.CommonContainer.NetworkSettings.Networks["bridge"].MacAddress
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if ip := lc.leasedAddress(mac); ip != nil {
+			lc.container.CommonContainer.NetworkSettings.Networks["bridge"].IPAddress = ip.String()
+			return ip, nil
+		}
+
+		time.Sleep(time.Second)
+	}
+
+	return nil, fmt.Errorf("Timed out waiting for a DHCP lease for domain %s", lc.container.ID)
+}
+
+// leasedAddress checks every lease source once and returns the first IPv4
+// address found for mac, or nil if none of the sources have one yet.
+func (lc *LibvirtContext) leasedAddress(mac string) net.IP {
+	for _, source := range leaseSources {
+		ifaces, err := lc.domain.ListAllInterfaceAddresses(source)
+		if err != nil {
+			continue
+		}
+
+		for _, iface := range ifaces {
+			if !strings.EqualFold(iface.Hwaddr, mac) {
+				continue
+			}
+
+			for _, addr := range iface.Addrs {
+				if ip := net.ParseIP(addr.Addr); ip != nil && ip.To4() != nil {
+					return ip
+				}
+			}
+		}
+	}
+
+	return nil
+}
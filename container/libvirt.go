@@ -1,192 +1,45 @@
 package container
 
 import (
-	"encoding/xml"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"os"
 	"os/exec"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
 	libvirtgo "github.com/rgbkrk/libvirt-go"
 )
 
 var connectionAddress = "qemu:///system"
 
+// FirmwareConfig selects the guest boot firmware. The zero value boots the
+// traditional SeaBIOS path; setting Firmware to "uefi" boots OVMF with a
+// per-domain NVRAM store copied from NVRAMTemplate.
+type FirmwareConfig struct {
+	Firmware      string
+	LoaderPath    string
+	NVRAMTemplate string
+}
+
+const (
+	defaultOvmfLoaderPath    = "/usr/share/OVMF/OVMF_CODE.fd"
+	defaultOvmfNVRAMTemplate = "/usr/share/OVMF/OVMF_VARS.fd"
+)
+
 type vmBaseConfig struct {
 	numCPU           int
 	DefaultMaxCpus   int
 	DefaultMaxMem    int
 	Memory           int
 	OriginalDiskPath string
-}
-
-type memory struct {
-	Unit    string `xml:"unit,attr"`
-	Content int    `xml:",chardata"`
-}
-
-type maxmem struct {
-	Unit    string `xml:"unit,attr"`
-	Slots   string `xml:"slots,attr"`
-	Content int    `xml:",chardata"`
-}
-
-type vcpu struct {
-	Placement string `xml:"placement,attr"`
-	Current   string `xml:"current,attr"`
-	Content   int    `xml:",chardata"`
-}
-
-type cell struct {
-	Id     string `xml:"id,attr"`
-	Cpus   string `xml:"cpus,attr"`
-	Memory string `xml:"memory,attr"`
-	Unit   string `xml:"unit,attr"`
-}
-
-type cpu struct {
-	Mode string `xml:"mode,attr"`
-}
-
-type ostype struct {
-	Arch    string `xml:"arch,attr"`
-	Machine string `xml:"machine,attr"`
-	Content string `xml:",chardata"`
-}
-
-type domainos struct {
-	Supported string `xml:"supported,attr"`
-	Type      ostype `xml:"type"`
-}
-
-type feature struct {
-	Acpi acpi `xml:"acpi"`
-}
-
-type acpi struct {
-}
-
-type fspath struct {
-	Dir string `xml:"dir,attr"`
-}
-
-type filesystem struct {
-	Type       string `xml:"type,attr"`
-	Accessmode string `xml:"accessmode,attr"`
-	Source     fspath `xml:"source"`
-	Target     fspath `xml:"target"`
-}
-
-type diskdriver struct {
-	Type string `xml:"type,attr"`
-	Name string `xml:"name,attr"`
-}
-
-type disksource struct {
-	File string `xml:"file,attr"`
-}
-
-type diskformat struct {
-	Type string `xml:"type,attr"`
-}
-
-type backingstore struct {
-	Type   string     `xml:"type,attr"`
-	Index  string     `xml:"index,attr"`
-	Format diskformat `xml:"format"`
-	Source disksource `xml:"source"`
-}
-
-type disktarget struct {
-	Dev string `xml:"dev,attr"`
-	Bus string `xml:"bus,attr"`
-}
-
-type readonly struct {
-}
-
-type controller struct {
-	Type  string `xml:"type,attr"`
-	Model string `xml:"model,attr"`
-}
-
-type disk struct {
-	Type         string        `xml:"type,attr"`
-	Device       string        `xml:"device,attr"`
-	Driver       diskdriver    `xml:"driver"`
-	Source       disksource    `xml:"source"`
-	BackingStore *backingstore `xml:"backingstore,omitempty"`
-	Target       disktarget    `xml:"target"`
-	Readonly     *readonly     `xml:"readonly,omitempty"`
-}
-
-type channsrc struct {
-	Mode string `xml:"mode,attr"`
-	Path string `xml:"path,attr"`
-}
-
-type constgt struct {
-	Type string `xml:"type,attr,omitempty"`
-	Port string `xml:"port,attr"`
-}
-
-type console struct {
-	Type   string   `xml:"type,attr"`
-	Source channsrc `xml:"source"`
-	Target constgt  `xml:"target"`
-}
-
-type device struct {
-	Emulator          string       `xml:"emulator"`
-	Filesystems       []filesystem `xml:"filesystem"`
-	Disks             []disk       `xml:"disk"`
-	Consoles          []console    `xml:"console"`
-	NetworkInterfaces []nic        `xml:"interface"`
-	Controller        []controller `xml:"controller"`
-}
-
-type seclab struct {
-	Type string `xml:"type,attr"`
-}
-
-type domain struct {
-	XMLName    xml.Name  `xml:"domain"`
-	Type       string    `xml:"type,attr"`
-	Name       string    `xml:"name"`
-	Memory     memory    `xml:"memory"`
-	MaxMem     *maxmem   `xml:"maxMemory,omitempty"`
-	VCpu       vcpu      `xml:"vcpu"`
-	OS         domainos  `xml:"os"`
-	Features   []feature `xml:"features"`
-	CPU        cpu       `xml:"cpu"`
-	OnPowerOff string    `xml:"on_poweroff"`
-	OnReboot   string    `xml:"on_reboot"`
-	OnCrash    string    `xml:"on_crash"`
-	Devices    device    `xml:"devices"`
-	SecLabel   seclab    `xml:"seclabel"`
-}
-
-type nicmac struct {
-	Address string `xml:"address,attr"`
-}
-
-type nicsrc struct {
-	Bridge string `xml:"bridge,attr"`
-}
-
-type nicmodel struct {
-	Type string `xml:"type,attr"`
-}
-
-type nic struct {
-	Type   string   `xml:"type,attr"`
-	Mac    nicmac   `xml:"mac"`
-	Source nicsrc   `xml:"source"`
-	Model  nicmodel `xml:"model"`
+	Firmware         FirmwareConfig
+	Accelerator      Accelerator
 }
 
 func (container *Container) InitDriver() *LibvirtDriver {
@@ -196,12 +49,16 @@ func (container *Container) InitDriver() *LibvirtDriver {
 		return nil
 	}
 
+	// Probe KVM/HVF/TCG once, at driver-init time, rather than on every
+	// DomainXml() call.
+	detectedAccelerator()
+
 	return &LibvirtDriver{
 		conn: conn,
 	}
 }
 
-func (ld *LibvirtDriver) InitContext(c *Container) *LibvirtContext {
+func (ld *LibvirtDriver) InitContext(c *Container) VMContext {
 	return &LibvirtContext{
 		driver:    ld,
 		container: c,
@@ -209,6 +66,13 @@ func (ld *LibvirtDriver) InitContext(c *Container) *LibvirtContext {
 }
 
 func (lc *LibvirtContext) CreateSeedImage(seedDirectory string) (string, error) {
+	return createSeedImage(lc.container, seedDirectory)
+}
+
+// createSeedImage builds a NoCloud seed.img for c in seedDirectory. It's a
+// free function rather than a LibvirtContext method so the qemu sub-driver
+// can share it without depending on libvirt.
+func createSeedImage(c *Container, seedDirectory string) (string, error) {
 	getisoimagePath, err := exec.LookPath("genisoimage")
 	if err != nil {
 		return "", fmt.Errorf("genisoimage is not installed on your PATH. Please, install it to run isolated container")
@@ -222,19 +86,10 @@ runcmd:
  - init 0
 `
 
-	metaDataString := `#cloud-config
-network-interfaces: |
-  auto eth0
-  iface eth0 inet static
-  address %s
-  netmask %s
-  gateway %s
-`
-
 	var command string
-	if len(lc.container.Args) > 0 {
+	if len(c.Args) > 0 {
 		args := []string{}
-		for _, arg := range lc.container.Args {
+		for _, arg := range c.Args {
 			if strings.Contains(arg, " ") {
 				args = append(args, fmt.Sprintf("'%s'", arg))
 			} else {
@@ -243,25 +98,21 @@ network-interfaces: |
 		}
 		argsAsString := strings.Join(args, " ")
 
-		command = fmt.Sprintf("%s %s", lc.container.Path, argsAsString)
+		command = fmt.Sprintf("%s %s", c.Path, argsAsString)
 	} else {
-		command = lc.container.Path
+		command = c.Path
 	}
 
-	// TODO - move this to a separate method
-	cidrIP := lc.container.NetworkSettings.Networks["bridge"].IPAddress + "/" + strconv.Itoa(lc.container.NetworkSettings.Networks["bridge"].IPPrefixLen)
-	_, IPnet, err := net.ParseCIDR(cidrIP)
+	networkConfig, err := cloudInitNetworkConfig(c)
 	if err != nil {
-		return "", fmt.Errorf("Could not parse CIDR")
+		return "", err
 	}
 
-	netMask := strconv.Itoa(int(IPnet.Mask[0])) + "." + strconv.Itoa(int(IPnet.Mask[1])) + "." + strconv.Itoa(int(IPnet.Mask[2])) + "." + strconv.Itoa(int(IPnet.Mask[3]))
-
 	logrus.Debugf("The user data is: %s", fmt.Sprintf(userDataString, command))
-	logrus.Debugf("The meta data is: %s", fmt.Sprintf(metaDataString, lc.container.NetworkSettings.Networks["bridge"].IPAddress, netMask, lc.container.NetworkSettings.Networks["bridge"].Gateway))
+	logrus.Debugf("The meta data is: %s", networkConfig)
 
 	userData := []byte(fmt.Sprintf(userDataString, command))
-	metaData := []byte(fmt.Sprintf(metaDataString, lc.container.NetworkSettings.Networks["bridge"].IPAddress, netMask, lc.container.NetworkSettings.Networks["bridge"].Gateway))
+	metaData := []byte(networkConfig)
 
 	currentDir, err := os.Getwd()
 	if err != nil {
@@ -275,12 +126,12 @@ network-interfaces: |
 
 	writeErrorUserData := ioutil.WriteFile("user-data", userData, 0700)
 	if writeErrorUserData != nil {
-		return "", fmt.Errorf("Could not write user-data to /var/run/docker-qemu/%s", lc.container.ID)
+		return "", fmt.Errorf("Could not write user-data to /var/run/docker-qemu/%s", c.ID)
 	}
 
 	writeErrorMetaData := ioutil.WriteFile("meta-data", metaData, 0700)
 	if writeErrorMetaData != nil {
-		return "", fmt.Errorf("Could not write meta-data to /var/run/docker-qemu/%s", lc.container.ID)
+		return "", fmt.Errorf("Could not write meta-data to /var/run/docker-qemu/%s", c.ID)
 	}
 
 	logrus.Debugf("genisoimage path: %s", getisoimagePath)
@@ -298,7 +149,52 @@ network-interfaces: |
 	return seedDirectory + "/seed.img", nil
 }
 
+// cloudInitNetworkConfig builds the NoCloud meta-data for c's selected
+// NetworkMode. NetworkModeNone has no NIC to configure; NetworkModeUser is
+// on qemu's own SLIRP DHCP server rather than the docker bridge network, so
+// it asks for DHCP instead of a static bridge address. NetworkModeTap and
+// NetworkModeBridge keep the original static config, since both put the
+// guest directly on the bridge network and need its address pinned.
+func cloudInitNetworkConfig(c *Container) (string, error) {
+	mode := NetworkMode(c.Config.NetworkMode)
+	if mode == "" {
+		mode = NetworkModeBridge
+	}
+
+	switch mode {
+	case NetworkModeNone:
+		return "#cloud-config\n", nil
+
+	case NetworkModeUser:
+		return "#cloud-config\nnetwork-interfaces: |\n  auto eth0\n  iface eth0 inet dhcp\n", nil
+
+	default: // NetworkModeTap, NetworkModeBridge
+		bridgeNetwork, ok := c.NetworkSettings.Networks["bridge"]
+		if !ok {
+			return "", fmt.Errorf("NetworkMode %q has no \"bridge\" network endpoint to derive a static address from", mode)
+		}
+
+		cidrIP := bridgeNetwork.IPAddress + "/" + strconv.Itoa(bridgeNetwork.IPPrefixLen)
+		_, IPnet, err := net.ParseCIDR(cidrIP)
+		if err != nil {
+			return "", fmt.Errorf("Could not parse CIDR")
+		}
+
+		netMask := strconv.Itoa(int(IPnet.Mask[0])) + "." + strconv.Itoa(int(IPnet.Mask[1])) + "." + strconv.Itoa(int(IPnet.Mask[2])) + "." + strconv.Itoa(int(IPnet.Mask[3]))
+
+		return fmt.Sprintf("#cloud-config\nnetwork-interfaces: |\n  auto eth0\n  iface eth0 inet static\n  address %s\n  netmask %s\n  gateway %s\n",
+			bridgeNetwork.IPAddress, netMask, bridgeNetwork.Gateway), nil
+	}
+}
+
 func (lc *LibvirtContext) CreateDeltaDiskImage(deltaDiskDirectory, diskPath string) (string, error) {
+	return createDeltaDiskImage(deltaDiskDirectory, diskPath)
+}
+
+// createDeltaDiskImage is a free function alongside createSeedImage so the
+// qemu sub-driver can build its own delta disk without depending on
+// libvirt.
+func createDeltaDiskImage(deltaDiskDirectory, diskPath string) (string, error) {
 	deltaImagePath, err := exec.LookPath("qemu-img")
 	if err != nil {
 		return "", fmt.Errorf("qemu-img is not installed on your PATH. Please, install it to run isolated qemu container")
@@ -327,6 +223,48 @@ func (lc *LibvirtContext) CreateDeltaDiskImage(deltaDiskDirectory, diskPath stri
 	return deltaDiskDirectory + "/disk.img", nil
 }
 
+// CreateNvramFile stamps out a per-domain UEFI variable store in
+// nvramDirectory by copying it from templatePath, mirroring the way the
+// cloud-init seed image is materialized per container.
+func (lc *LibvirtContext) CreateNvramFile(nvramDirectory, templatePath string) (string, error) {
+	nvramPath := nvramDirectory + "/nvram.fd"
+
+	template, err := os.Open(templatePath)
+	if err != nil {
+		return "", fmt.Errorf("Could not open NVRAM template %s", templatePath)
+	}
+	defer template.Close()
+
+	nvram, err := os.Create(nvramPath)
+	if err != nil {
+		return "", fmt.Errorf("Could not create NVRAM file %s", nvramPath)
+	}
+	defer nvram.Close()
+
+	if _, err := io.Copy(nvram, template); err != nil {
+		return "", fmt.Errorf("Could not copy NVRAM template to %s", nvramPath)
+	}
+
+	return nvramPath, nil
+}
+
+// unixConsole builds a unix-socket-backed <console> device of the given
+// target type (e.g. "serial", "virtio") bound to path on the given port.
+func unixConsole(targetType string, port uint, path string) libvirtxml.DomainConsole {
+	return libvirtxml.DomainConsole{
+		Source: &libvirtxml.DomainChardevSource{
+			UNIX: &libvirtxml.DomainChardevSourceUNIX{
+				Mode: "bind",
+				Path: path,
+			},
+		},
+		Target: &libvirtxml.DomainConsoleTarget{
+			Type: targetType,
+			Port: &port,
+		},
+	}
+}
+
 func (lc *LibvirtContext) DomainXml() (string, error) {
 	baseCfg := &vmBaseConfig{
 		numCPU:           1,
@@ -334,6 +272,12 @@ func (lc *LibvirtContext) DomainXml() (string, error) {
 		DefaultMaxMem:    256,
 		Memory:           256,
 		OriginalDiskPath: "/var/lib/libvirt/images/disk.img.orig",
+		Firmware: FirmwareConfig{
+			Firmware:      lc.container.Config.Firmware,
+			LoaderPath:    defaultOvmfLoaderPath,
+			NVRAMTemplate: defaultOvmfNVRAMTemplate,
+		},
+		Accelerator: detectedAccelerator(),
 	}
 
 	// Create directory for seed image and delta disk image
@@ -346,158 +290,152 @@ func (lc *LibvirtContext) DomainXml() (string, error) {
 
 	logrus.Debugf("Delta disk image location: %s", deltaDiskImageLocation)
 
-	// Domain XML Formation
-	dom := &domain{
-		Type: "kvm",
-		Name: lc.container.ID[0:12],
-	}
+	arch, machine := osArchMachine()
 
-	dom.Memory.Unit = "MiB"
-	dom.Memory.Content = baseCfg.Memory
-
-	dom.VCpu.Current = strconv.Itoa(baseCfg.numCPU)
-	dom.VCpu.Content = baseCfg.numCPU
-
-	dom.OS.Supported = "yes"
-	dom.OS.Type.Content = "hvm"
-
-	acpiFeature := feature{
-		Acpi: acpi{},
+	domainOS := &libvirtxml.DomainOS{
+		Type: &libvirtxml.DomainOSType{
+			Type:    "hvm",
+			Arch:    arch,
+			Machine: machine,
+		},
 	}
-	dom.Features = append(dom.Features, acpiFeature)
 
-	dom.SecLabel.Type = "none"
+	if baseCfg.Firmware.Firmware == "uefi" {
+		nvramPath, err := lc.CreateNvramFile(directory, baseCfg.Firmware.NVRAMTemplate)
+		if err != nil {
+			return "", fmt.Errorf("Could not create NVRAM file")
+		}
+		logrus.Debugf("NVRAM file location: %s", nvramPath)
 
-	dom.CPU.Mode = "host-model"
+		domainOS.Loader = &libvirtxml.DomainLoader{
+			Path:     baseCfg.Firmware.LoaderPath,
+			Readonly: "yes",
+			Type:     "pflash",
+		}
+		domainOS.NVRam = &libvirtxml.DomainNVRam{
+			NVRam:    nvramPath,
+			Template: baseCfg.Firmware.NVRAMTemplate,
+		}
+	}
 
-	dom.OnPowerOff = "destroy"
-	dom.OnReboot = "destroy"
-	dom.OnCrash = "destroy"
+	// Domain XML Formation
+	dom := &libvirtxml.Domain{
+		Type: baseCfg.Accelerator.domainType(),
+		Name: lc.container.ID[0:12],
+		Memory: &libvirtxml.DomainMemory{
+			Value: uint(baseCfg.Memory),
+			Unit:  "MiB",
+		},
+		VCPU: &libvirtxml.DomainVCPU{
+			Current: uint(baseCfg.numCPU),
+			Value:   uint(baseCfg.numCPU),
+		},
+		OS: domainOS,
+		Features: &libvirtxml.DomainFeatureList{
+			ACPI: &libvirtxml.DomainFeature{},
+		},
+		CPU:        baseCfg.Accelerator.cpu(),
+		OnPoweroff: "destroy",
+		OnReboot:   "destroy",
+		OnCrash:    "destroy",
+		SecLabel: []libvirtxml.DomainSecLabel{
+			{Type: "none"},
+		},
+		Devices: &libvirtxml.DomainDeviceList{},
+	}
 
-	diskimage := disk{
-		Type:   "file",
+	diskimage := libvirtxml.DomainDisk{
 		Device: "disk",
-		Driver: diskdriver{
+		Driver: &libvirtxml.DomainDiskDriver{
 			Name: "qemu",
 			Type: "qcow2",
 		},
-		Source: disksource{
-			File: deltaDiskImageLocation,
+		Source: &libvirtxml.DomainDiskSource{
+			File: &libvirtxml.DomainDiskSourceFile{
+				File: deltaDiskImageLocation,
+			},
 		},
-		BackingStore: &backingstore{
-			Type:  "file",
-			Index: "1",
-			Format: diskformat{
+		BackingStore: &libvirtxml.DomainDiskBackingStore{
+			Index: 1,
+			Format: &libvirtxml.DomainDiskFormat{
 				Type: "raw",
 			},
-			Source: disksource{
-				File: baseCfg.OriginalDiskPath,
+			Source: &libvirtxml.DomainDiskSource{
+				File: &libvirtxml.DomainDiskSourceFile{
+					File: baseCfg.OriginalDiskPath,
+				},
 			},
 		},
-		Target: disktarget{
+		Target: &libvirtxml.DomainDiskTarget{
 			Dev: "sda",
 			Bus: "scsi",
 		},
 	}
 	dom.Devices.Disks = append(dom.Devices.Disks, diskimage)
 
-	seedimage := disk{
-		Type:   "file",
-		Device: "cdrom",
-		Driver: diskdriver{
-			Name: "qemu",
-			Type: "raw",
-		},
-		Source: disksource{
-			File: fmt.Sprintf("%s/seed.img", lc.container.Config.QemuDirectory),
-		},
-		Target: disktarget{
-			Dev: "sdb",
-			Bus: "scsi",
-		},
-		Readonly: &readonly{},
+	cloudInit := cloudInitProvider(lc.container.Config.QemuCloudInit)
+	cloudInitArtifacts, err := cloudInit.Prepare(lc, directory)
+	if err != nil {
+		return "", fmt.Errorf("Could not prepare cloud-init provider: %s", err)
+	}
+	if cloudInitArtifacts.Disk != nil {
+		dom.Devices.Disks = append(dom.Devices.Disks, *cloudInitArtifacts.Disk)
 	}
-	dom.Devices.Disks = append(dom.Devices.Disks, seedimage)
 
-	storageController := controller{
+	storageController := libvirtxml.DomainController{
 		Type:  "scsi",
 		Model: "virtio-scsi",
 	}
-	dom.Devices.Controller = append(dom.Devices.Controller, storageController)
+	dom.Devices.Controllers = append(dom.Devices.Controllers, storageController)
 
 	macAddress := lc.container.CommonContainer.NetworkSettings.Networks["bridge"].MacAddress
-	networkInterface := nic{
-		Type: "bridge",
-		Mac: nicmac{
-			Address: macAddress,
-		},
-		Source: nicsrc{
-			Bridge: "docker0",
-		},
-		Model: nicmodel{
-			Type: "virtio",
-		},
+	networkMode := NetworkMode(lc.container.Config.NetworkMode)
+	if networkMode == "" {
+		networkMode = NetworkModeBridge
 	}
-	dom.Devices.NetworkInterfaces = append(dom.Devices.NetworkInterfaces, networkInterface)
-
-	fs := filesystem{
-		Type:       "mount",
-		Accessmode: "passthrough",
-		Source: fspath{
-			Dir: lc.container.BaseFS,
-		},
-		Target: fspath{
-			Dir: "share_dir",
-		},
+	networkInterface, networkCommandline := networkDevice(lc.container.ID, macAddress, networkMode, lc.container.Config.Bridge, lc.container.Config.Publish)
+	if networkInterface != nil {
+		dom.Devices.Interfaces = append(dom.Devices.Interfaces, *networkInterface)
 	}
-	dom.Devices.Filesystems = append(dom.Devices.Filesystems, fs)
 
-	serialConsole := console{
-		Type: "unix",
-		Source: channsrc{
-			Mode: "bind",
-			Path: fmt.Sprintf("%s/serial.sock", lc.container.Config.QemuDirectory),
-		},
-		Target: constgt{
-			Type: "serial",
-			Port: "0",
-		},
+	var qemuArgs []libvirtxml.DomainQEMUCommandlineArg
+	if cloudInitArtifacts.QEMUCommandline != nil {
+		qemuArgs = append(qemuArgs, cloudInitArtifacts.QEMUCommandline.Args...)
 	}
-	dom.Devices.Consoles = append(dom.Devices.Consoles, serialConsole)
-	logrus.Debugf("Serial console socket location: %s", fmt.Sprintf("%s/serial.sock", lc.container.Config.QemuDirectory))
-
-	vmConsole := console{
-		Type: "unix",
-		Source: channsrc{
-			Mode: "bind",
-			Path: fmt.Sprintf("%s/arbritary.sock", lc.container.Config.QemuDirectory),
-		},
-		Target: constgt{
-			Type: "virtio",
-			Port: "1",
-		},
+	if networkCommandline != nil {
+		qemuArgs = append(qemuArgs, networkCommandline.Args...)
+	}
+	if accelCommandline := baseCfg.Accelerator.qemuCommandline(); accelCommandline != nil {
+		qemuArgs = append(qemuArgs, accelCommandline.Args...)
+	}
+	if len(qemuArgs) > 0 {
+		dom.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{Args: qemuArgs}
 	}
-	dom.Devices.Consoles = append(dom.Devices.Consoles, vmConsole)
 
-	appConsole := console{
-		Type: "unix",
-		Source: channsrc{
-			Mode: "bind",
-			Path: fmt.Sprintf("%s/app.sock", lc.container.Config.QemuDirectory),
+	fs := libvirtxml.DomainFilesystem{
+		AccessMode: "passthrough",
+		Source: &libvirtxml.DomainFilesystemSource{
+			Mount: &libvirtxml.DomainFilesystemSourceMount{
+				Dir: lc.container.BaseFS,
+			},
 		},
-		Target: constgt{
-			Type: "virtio",
-			Port: "2",
+		Target: &libvirtxml.DomainFilesystemTarget{
+			Dir: "share_dir",
 		},
 	}
-	dom.Devices.Consoles = append(dom.Devices.Consoles, appConsole)
-	logrus.Debugf("Application console socket location: %s", fmt.Sprintf("%s/app.sock", lc.container.Config.QemuDirectory))
+	dom.Devices.Filesystems = append(dom.Devices.Filesystems, fs)
 
-	data, err := xml.Marshal(dom)
-	if err != nil {
-		return "", err
-	}
-	return string(data), nil
+	serialSockPath := fmt.Sprintf("%s/serial.sock", lc.container.Config.QemuDirectory)
+	appSockPath := fmt.Sprintf("%s/app.sock", lc.container.Config.QemuDirectory)
+	dom.Devices.Consoles = append(dom.Devices.Consoles,
+		unixConsole("serial", 0, serialSockPath),
+		unixConsole("virtio", 1, fmt.Sprintf("%s/arbritary.sock", lc.container.Config.QemuDirectory)),
+		unixConsole("virtio", 2, appSockPath),
+	)
+	logrus.Debugf("Serial console socket location: %s", serialSockPath)
+	logrus.Debugf("Application console socket location: %s", appSockPath)
+
+	return dom.Marshal()
 }
 
 func (lc *LibvirtContext) GetDomain() *libvirtgo.VirDomain {
@@ -508,6 +446,13 @@ func (lc *LibvirtContext) GetQemuDirectory() string {
 	return lc.container.Config.QemuDirectory
 }
 
+// GetConsolePath returns the application console socket set up in
+// DomainXml(), satisfying VMContext for callers that attach to the
+// container's console.
+func (lc *LibvirtContext) GetConsolePath() string {
+	return fmt.Sprintf("%s/app.sock", lc.container.Config.QemuDirectory)
+}
+
 func (lc *LibvirtContext) CreateDomain() {
 	domainXml, err := lc.DomainXml()
 	if err != nil {
@@ -527,19 +472,43 @@ func (lc *LibvirtContext) CreateDomain() {
 
 }
 
-func (lc *LibvirtContext) Launch() {
+// Launch starts the domain and, in bridge and tap mode, blocks until its NIC
+// has acquired an IP address or timeout elapses, so callers can treat a
+// returning Launch as "the container is running". In none and user mode it
+// returns as soon as the domain has started, without waiting for an address.
+func (lc *LibvirtContext) Launch(timeout time.Duration) error {
 	if lc.domain == nil {
-		logrus.Error("Failed to launch domain as no domain in LibvirtContext")
-		return
+		return fmt.Errorf("Failed to launch domain as no domain in LibvirtContext")
 	}
 
-	err := lc.domain.Create()
-	if err != nil {
-		logrus.Error("Fail to start qemu isolated container ", err)
-		return
+	if err := lc.CreateTapDevice(); err != nil {
+		return fmt.Errorf("Fail to create tap device: %s", err)
+	}
+
+	if err := lc.domain.Create(); err != nil {
+		return fmt.Errorf("Fail to start qemu isolated container: %s", err)
 	}
 
 	logrus.Infof("Domain has started: %v", lc.container.ID)
+
+	ip, err := lc.WaitForLeases(timeout)
+	if err != nil {
+		// The domain is running and defined, but the caller is about to be
+		// told Launch failed - don't leave an orphaned VM behind for a
+		// failure it believes never started.
+		lc.domain.DestroyFlags(libvirtgo.VIR_DOMAIN_DESTROY_DEFAULT)
+		if undefineErr := lc.domain.UndefineFlags(libvirtgo.VIR_DOMAIN_UNDEFINE_NVRAM); undefineErr != nil {
+			logrus.Errorf("Failed to undefine domain %s after lease timeout: %v", lc.container.ID, undefineErr)
+		}
+		if tapErr := lc.DeleteTapDevice(); tapErr != nil {
+			logrus.Errorf("Failed to delete tap device for domain %s after lease timeout: %v", lc.container.ID, tapErr)
+		}
+		return err
+	}
+	if ip != nil {
+		logrus.Infof("Domain %s acquired IP %s", lc.container.ID, ip)
+	}
+	return nil
 }
 
 func (lc *LibvirtContext) Shutdown() {
@@ -548,6 +517,11 @@ func (lc *LibvirtContext) Shutdown() {
 	}
 
 	lc.domain.DestroyFlags(libvirtgo.VIR_DOMAIN_DESTROY_DEFAULT)
+
+	if err := lc.DeleteTapDevice(); err != nil {
+		logrus.Error("Fail to delete tap device ", err)
+	}
+
 	logrus.Infof("Domain has shutdown: %v", lc.container.ID)
 }
 
@@ -555,7 +529,10 @@ func (lc *LibvirtContext) Undefine() {
 	if lc.domain == nil {
 		return
 	}
-	err := lc.domain.Undefine()
+	// VIR_DOMAIN_UNDEFINE_NVRAM also removes the per-domain UEFI variable
+	// store created in DomainXml() when booting with OVMF; it's a no-op
+	// for domains without one.
+	err := lc.domain.UndefineFlags(libvirtgo.VIR_DOMAIN_UNDEFINE_NVRAM)
 	if err == nil {
 		logrus.Infof("Domain is undefined: %v", lc.container.ID)
 	} else {
@@ -0,0 +1,191 @@
+package container
+
+import (
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// These tests cover the disk, nic, filesystem and console elements that
+// DomainXml() assembles via libvirtxml, in place of the hand-rolled structs
+// it replaced. Each element is marshaled, checked against the attributes the
+// old hand-rolled marshaler used to emit, and then unmarshaled back to prove
+// the round trip is lossless.
+
+func TestDiskXMLRoundTrip(t *testing.T) {
+	disk := libvirtxml.DomainDisk{
+		Device: "disk",
+		Driver: &libvirtxml.DomainDiskDriver{
+			Name: "qemu",
+			Type: "qcow2",
+		},
+		Source: &libvirtxml.DomainDiskSource{
+			File: &libvirtxml.DomainDiskSourceFile{
+				File: "/var/lib/docker-qemu/abc123/disk.img",
+			},
+		},
+		BackingStore: &libvirtxml.DomainDiskBackingStore{
+			Index: 1,
+			Format: &libvirtxml.DomainDiskFormat{
+				Type: "raw",
+			},
+			Source: &libvirtxml.DomainDiskSource{
+				File: &libvirtxml.DomainDiskSourceFile{
+					File: "/var/lib/libvirt/images/disk.img.orig",
+				},
+			},
+		},
+		Target: &libvirtxml.DomainDiskTarget{
+			Dev: "sda",
+			Bus: "scsi",
+		},
+	}
+
+	xmlDoc, err := disk.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		`device="disk"`,
+		`name="qemu" type="qcow2"`,
+		`file="/var/lib/docker-qemu/abc123/disk.img"`,
+		`index="1"`,
+		`type="raw"`,
+		`file="/var/lib/libvirt/images/disk.img.orig"`,
+		`dev="sda" bus="scsi"`,
+	} {
+		if !strings.Contains(xmlDoc, want) {
+			t.Errorf("disk XML missing %q, got:\n%s", want, xmlDoc)
+		}
+	}
+
+	var roundTripped libvirtxml.DomainDisk
+	if err := roundTripped.Unmarshal(xmlDoc); err != nil {
+		t.Fatalf("Unmarshal() returned error: %v", err)
+	}
+	if roundTripped.Source.File.File != disk.Source.File.File {
+		t.Errorf("disk source did not round-trip: got %q, want %q", roundTripped.Source.File.File, disk.Source.File.File)
+	}
+	if roundTripped.BackingStore.Source.File.File != disk.BackingStore.Source.File.File {
+		t.Errorf("disk backing store did not round-trip: got %q, want %q", roundTripped.BackingStore.Source.File.File, disk.BackingStore.Source.File.File)
+	}
+	if roundTripped.Target.Dev != disk.Target.Dev || roundTripped.Target.Bus != disk.Target.Bus {
+		t.Errorf("disk target did not round-trip: got %+v, want %+v", roundTripped.Target, disk.Target)
+	}
+}
+
+func TestNetworkDeviceBridgeXMLRoundTrip(t *testing.T) {
+	iface, cmdline := networkDevice("abcdef1234567890", "52:54:00:12:34:56", NetworkModeBridge, "docker0", nil)
+	if cmdline != nil {
+		t.Fatalf("NetworkModeBridge should not need a qemu:commandline override, got %+v", cmdline)
+	}
+	if iface == nil {
+		t.Fatal("NetworkModeBridge should return an <interface>")
+	}
+
+	xmlDoc, err := iface.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		`address="52:54:00:12:34:56"`,
+		`bridge="docker0"`,
+		`type="virtio"`,
+	} {
+		if !strings.Contains(xmlDoc, want) {
+			t.Errorf("interface XML missing %q, got:\n%s", want, xmlDoc)
+		}
+	}
+
+	var roundTripped libvirtxml.DomainInterface
+	if err := roundTripped.Unmarshal(xmlDoc); err != nil {
+		t.Fatalf("Unmarshal() returned error: %v", err)
+	}
+	if roundTripped.MAC.Address != iface.MAC.Address {
+		t.Errorf("interface MAC did not round-trip: got %q, want %q", roundTripped.MAC.Address, iface.MAC.Address)
+	}
+	if roundTripped.Source.Bridge.Bridge != iface.Source.Bridge.Bridge {
+		t.Errorf("interface bridge did not round-trip: got %q, want %q", roundTripped.Source.Bridge.Bridge, iface.Source.Bridge.Bridge)
+	}
+}
+
+func TestNetworkDeviceNoneHasNoInterface(t *testing.T) {
+	iface, cmdline := networkDevice("abcdef1234567890", "", NetworkModeNone, "", nil)
+	if iface != nil || cmdline != nil {
+		t.Fatalf("NetworkModeNone should emit neither an interface nor a qemu:commandline override, got iface=%+v cmdline=%+v", iface, cmdline)
+	}
+}
+
+func TestFilesystemXMLRoundTrip(t *testing.T) {
+	fs := libvirtxml.DomainFilesystem{
+		AccessMode: "passthrough",
+		Source: &libvirtxml.DomainFilesystemSource{
+			Mount: &libvirtxml.DomainFilesystemSourceMount{
+				Dir: "/var/lib/docker/containers/abc123",
+			},
+		},
+		Target: &libvirtxml.DomainFilesystemTarget{
+			Dir: "share_dir",
+		},
+	}
+
+	xmlDoc, err := fs.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		`accessmode="passthrough"`,
+		`dir="/var/lib/docker/containers/abc123"`,
+		`dir="share_dir"`,
+	} {
+		if !strings.Contains(xmlDoc, want) {
+			t.Errorf("filesystem XML missing %q, got:\n%s", want, xmlDoc)
+		}
+	}
+
+	var roundTripped libvirtxml.DomainFilesystem
+	if err := roundTripped.Unmarshal(xmlDoc); err != nil {
+		t.Fatalf("Unmarshal() returned error: %v", err)
+	}
+	if roundTripped.Source.Mount.Dir != fs.Source.Mount.Dir {
+		t.Errorf("filesystem source did not round-trip: got %q, want %q", roundTripped.Source.Mount.Dir, fs.Source.Mount.Dir)
+	}
+	if roundTripped.Target.Dir != fs.Target.Dir {
+		t.Errorf("filesystem target did not round-trip: got %q, want %q", roundTripped.Target.Dir, fs.Target.Dir)
+	}
+}
+
+func TestUnixConsoleXMLRoundTrip(t *testing.T) {
+	console := unixConsole("virtio", 2, "/var/run/docker-qemu/abc123/app.sock")
+
+	xmlDoc, err := console.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() returned error: %v", err)
+	}
+
+	for _, want := range []string{
+		`mode="bind"`,
+		`path="/var/run/docker-qemu/abc123/app.sock"`,
+		`type="virtio"`,
+		`port="2"`,
+	} {
+		if !strings.Contains(xmlDoc, want) {
+			t.Errorf("console XML missing %q, got:\n%s", want, xmlDoc)
+		}
+	}
+
+	var roundTripped libvirtxml.DomainConsole
+	if err := roundTripped.Unmarshal(xmlDoc); err != nil {
+		t.Fatalf("Unmarshal() returned error: %v", err)
+	}
+	if roundTripped.Source.UNIX.Path != console.Source.UNIX.Path {
+		t.Errorf("console source did not round-trip: got %q, want %q", roundTripped.Source.UNIX.Path, console.Source.UNIX.Path)
+	}
+	if roundTripped.Target.Type != console.Target.Type || *roundTripped.Target.Port != *console.Target.Port {
+		t.Errorf("console target did not round-trip: got %+v, want %+v", roundTripped.Target, console.Target)
+	}
+}
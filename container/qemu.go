@@ -0,0 +1,236 @@
+package container
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// QemuDriver drives qemu-system-* directly as a child process, with no
+// libvirtd involved. Useful on developer laptops and inside unprivileged
+// containers where libvirtd isn't available.
+type QemuDriver struct{}
+
+func NewQemuDriver() *QemuDriver {
+	return &QemuDriver{}
+}
+
+func (qd *QemuDriver) InitContext(c *Container) VMContext {
+	// Probe KVM/HVF/TCG once, when the first context is initialized, rather
+	// than on every commandLine() call.
+	detectedAccelerator()
+
+	return &QemuContext{container: c}
+}
+
+// QemuContext is the qemu-system-* counterpart to LibvirtContext: same
+// vmBaseConfig defaults and delta disk/seed image layout, but launched as a
+// plain child process instead of being defined as a libvirt domain.
+type QemuContext struct {
+	container *Container
+	cmd       *exec.Cmd
+}
+
+func (qc *QemuContext) binary() string {
+	if runtime.GOARCH == "arm64" {
+		return "qemu-system-aarch64"
+	}
+	return "qemu-system-x86_64"
+}
+
+func (qc *QemuContext) commandLine() ([]string, error) {
+	baseCfg := &vmBaseConfig{
+		numCPU:           1,
+		DefaultMaxCpus:   2,
+		DefaultMaxMem:    256,
+		Memory:           256,
+		OriginalDiskPath: "/var/lib/libvirt/images/disk.img.orig",
+		Accelerator:      detectedAccelerator(),
+	}
+
+	// Firmware selection (chunk0-2) and the Ignition cloud-init provider
+	// (chunk0-3) both only know how to describe themselves as libvirt
+	// domain XML pieces; fail loudly instead of silently booting the
+	// container in a materially different configuration than the libvirt
+	// driver would.
+	if qc.container.Config.Firmware == "uefi" {
+		return nil, fmt.Errorf("uefi firmware is not supported by the qemu driver yet")
+	}
+	if qc.container.Config.QemuCloudInit == "ignition" {
+		return nil, fmt.Errorf("ignition cloud-init is not supported by the qemu driver yet")
+	}
+
+	directory := qc.container.Config.QemuDirectory
+
+	diskPath, err := createDeltaDiskImage(directory, baseCfg.OriginalDiskPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create delta disk image")
+	}
+
+	seedPath, err := createSeedImage(qc.container, directory)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create seed image")
+	}
+
+	serialSockPath := fmt.Sprintf("%s/serial.sock", directory)
+	arbitrarySockPath := fmt.Sprintf("%s/arbritary.sock", directory)
+	appSockPath := fmt.Sprintf("%s/app.sock", directory)
+
+	args := []string{
+		"-name", qc.container.ID[0:12],
+		"-m", strconv.Itoa(baseCfg.Memory),
+		"-smp", strconv.Itoa(baseCfg.numCPU),
+		"-drive", fmt.Sprintf("file=%s,if=virtio,format=qcow2", diskPath),
+		"-drive", fmt.Sprintf("file=%s,if=virtio,format=raw,readonly=on", seedPath),
+		"-virtfs", fmt.Sprintf("local,path=%s,mount_tag=share_dir,security_model=passthrough", qc.container.BaseFS),
+		"-nographic",
+		// Mirrors DomainXml()'s three unix-socket consoles: a plain serial
+		// port, and two virtio-console ports - the second of which is
+		// GetConsolePath()'s contract with callers that attach to it.
+		"-serial", fmt.Sprintf("unix:%s,server,nowait", serialSockPath),
+		"-device", "virtio-serial-pci",
+		"-chardev", fmt.Sprintf("socket,id=vioconsole1,path=%s,server,nowait", arbitrarySockPath),
+		"-device", "virtconsole,chardev=vioconsole1",
+		"-chardev", fmt.Sprintf("socket,id=vioconsole2,path=%s,server,nowait", appSockPath),
+		"-device", "virtconsole,chardev=vioconsole2",
+	}
+
+	switch baseCfg.Accelerator {
+	case AcceleratorKVM:
+		args = append(args, "-enable-kvm", "-cpu", "host")
+	case AcceleratorHVF:
+		args = append(args, "-accel", "hvf", "-cpu", "host")
+	default:
+		args = append(args, "-cpu", tcgCPUModel())
+	}
+
+	mac := qc.container.CommonContainer.NetworkSettings.Networks["bridge"].MacAddress
+	mode := NetworkMode(qc.container.Config.NetworkMode)
+	if mode == "" {
+		mode = NetworkModeBridge
+	}
+	args = append(args, qemuNetdevArgs(qc.container.ID, mac, mode, qc.container.Config.Bridge, qc.container.Config.Publish)...)
+
+	return args, nil
+}
+
+// CreateTapDevice creates the tap device NetworkModeTap plugs into. It's a
+// no-op outside that mode.
+func (qc *QemuContext) CreateTapDevice() error {
+	if NetworkMode(qc.container.Config.NetworkMode) != NetworkModeTap {
+		return nil
+	}
+
+	dev := tapDeviceName(qc.container.ID)
+	if err := createTapDevice(dev); err != nil {
+		return err
+	}
+
+	logrus.Debugf("Created tap device %s for container %s", dev, qc.container.ID)
+	return nil
+}
+
+// DeleteTapDevice tears down the tap device created by CreateTapDevice.
+func (qc *QemuContext) DeleteTapDevice() error {
+	if NetworkMode(qc.container.Config.NetworkMode) != NetworkModeTap {
+		return nil
+	}
+
+	dev := tapDeviceName(qc.container.ID)
+	if err := deleteTapDevice(dev); err != nil {
+		return err
+	}
+
+	logrus.Debugf("Deleted tap device %s for container %s", dev, qc.container.ID)
+	return nil
+}
+
+func (qc *QemuContext) CreateDomain() {
+	args, err := qc.commandLine()
+	if err != nil {
+		logrus.Error("Fail to build qemu command line: ", err)
+		return
+	}
+
+	qemuPath, err := exec.LookPath(qc.binary())
+	if err != nil {
+		logrus.Error(qc.binary(), " is not installed on your PATH. Please install it to run isolated qemu container")
+		return
+	}
+
+	qc.cmd = exec.Command(qemuPath, args...)
+}
+
+func (qc *QemuContext) Launch(timeout time.Duration) error {
+	if qc.cmd == nil {
+		return fmt.Errorf("Failed to launch domain as no qemu command in QemuContext")
+	}
+
+	if err := qc.CreateTapDevice(); err != nil {
+		return fmt.Errorf("Fail to create tap device: %s", err)
+	}
+
+	if err := qc.cmd.Start(); err != nil {
+		return fmt.Errorf("Fail to start qemu isolated container: %s", err)
+	}
+
+	logrus.Infof("Domain has started: %v", qc.container.ID)
+
+	// WaitForLeases has no lease source to poll for this backend yet; it
+	// never fails, so it can't strand the process we just started.
+	ip, err := qc.WaitForLeases(timeout)
+	if err != nil {
+		return err
+	}
+	if ip != nil {
+		logrus.Infof("Domain %s acquired IP %s", qc.container.ID, ip)
+	}
+	return nil
+}
+
+func (qc *QemuContext) Shutdown() {
+	if qc.cmd == nil || qc.cmd.Process == nil {
+		return
+	}
+
+	if err := qc.cmd.Process.Kill(); err != nil {
+		logrus.Error("Fail to stop qemu isolated container ", err)
+		return
+	}
+
+	if err := qc.DeleteTapDevice(); err != nil {
+		logrus.Error("Fail to delete tap device ", err)
+	}
+
+	logrus.Infof("Domain has shutdown: %v", qc.container.ID)
+}
+
+func (qc *QemuContext) Undefine() {
+	// Nothing is defined in libvirtd for this backend - the delta disk and
+	// seed image live under Container.Config.QemuDirectory and are cleaned
+	// up with the rest of the container's state.
+}
+
+func (qc *QemuContext) Close() {
+	qc.cmd = nil
+}
+
+func (qc *QemuContext) Pause(pause bool) error {
+	return fmt.Errorf("Pause is not supported by the qemu driver")
+}
+
+// WaitForLeases has no libvirt lease source to poll in this backend yet, so
+// it reports the limitation rather than pretending to wait.
+func (qc *QemuContext) WaitForLeases(timeout time.Duration) (net.IP, error) {
+	logrus.Debugf("WaitForLeases is not supported by the qemu driver yet; not waiting for domain %s", qc.container.ID)
+	return nil, nil
+}
+
+func (qc *QemuContext) GetConsolePath() string {
+	return fmt.Sprintf("%s/app.sock", qc.container.Config.QemuDirectory)
+}
@@ -0,0 +1,37 @@
+package container
+
+import (
+	"net"
+	"time"
+)
+
+// VMDriver selects and initializes a VM backend for a container. Each
+// backend (libvirt, qemu, and eventually Firecracker/Cloud Hypervisor)
+// implements this the same way Lima splits its qemu and vz drivers.
+type VMDriver interface {
+	InitContext(c *Container) VMContext
+}
+
+// VMContext drives a single VM-backed container through its lifecycle,
+// regardless of which VMDriver created it.
+type VMContext interface {
+	CreateDomain()
+	Launch(timeout time.Duration) error
+	Shutdown()
+	Pause(pause bool) error
+	Undefine()
+	Close()
+	WaitForLeases(timeout time.Duration) (net.IP, error)
+	GetConsolePath() string
+}
+
+// InitVMDriver selects a VMDriver for Container.Config.VMDriver, defaulting
+// to "libvirt" to preserve existing behavior.
+func (container *Container) InitVMDriver() VMDriver {
+	switch container.Config.VMDriver {
+	case "qemu":
+		return NewQemuDriver()
+	default:
+		return container.InitDriver()
+	}
+}